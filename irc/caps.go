@@ -0,0 +1,200 @@
+package irc
+
+import (
+	"strings"
+
+	"channels/state"
+)
+
+// capHandler lets a capability gate registration or adjust outbound framing
+// for the connection that negotiated it. Capabilities that need neither may
+// leave handler nil.
+type capHandler interface {
+	// onEnable runs when a client successfully REQs the capability.
+	onEnable(cs *capNegState)
+
+	// onDisable runs when a client drops a previously enabled capability
+	// via `CAP REQ -name`.
+	onDisable(cs *capNegState)
+}
+
+// capability describes a single IRCv3 capability the server can advertise.
+type capability struct {
+	// name is the bare token advertised in CAP LS/LIST/ACK.
+	name string
+
+	// value is appended as `name=value` in CAP LS 302 responses, per the
+	// capability value syntax in the IRCv3 spec. Empty for valueless caps.
+	value string
+
+	handler capHandler
+}
+
+// serverCaps is the registry of capabilities this server understands, keyed
+// by the wire token. REQ only succeeds for caps present here.
+var serverCaps = map[string]capability{
+	"message-tags": {name: "message-tags"},
+	"server-time":  {name: "server-time"},
+	"account-tag":  {name: "account-tag"},
+	"batch":        {name: "batch"},
+	"echo-message": {name: "echo-message"},
+	"cap-notify":   {name: "cap-notify"},
+	"sasl":         {name: "sasl", value: "PLAIN,EXTERNAL", handler: saslCap{}},
+}
+
+// capNegState is the IRCv3 CAP negotiation state for one not-yet-registered
+// connection. It is shared between freshHandler and the freshUserHandler it
+// hands off to, since CAP REQ/END may legally arrive after NICK and USER.
+type capNegState struct {
+	// negotiating is set by the first CAP LS or CAP REQ and stays set; ended
+	// records whether END has arrived since.
+	negotiating bool
+
+	// ended is true once CAP END has been received. Registration may not
+	// complete while negotiating is true and ended is false.
+	ended bool
+
+	// ls302 records whether the client asked for CAP LS 302, which adds
+	// capability values (e.g. the SASL mechanism list) to LS output.
+	ls302 bool
+
+	// caps holds the capabilities this connection has ACK'd.
+	caps map[string]struct{}
+
+	saslRequested bool
+	sasl          saslSession
+	saslAccount   string
+
+	// saslCaller is set once SASL authentication succeeds, letting NICK
+	// register against it directly instead of requiring PASS too.
+	saslCaller *state.Caller
+}
+
+func newCapNegState() *capNegState {
+	return &capNegState{caps: make(map[string]struct{})}
+}
+
+// capToken renders the LS 302 form of a capability, e.g. "sasl=PLAIN,EXTERNAL".
+func capToken(c capability) string {
+	if c.value == "" {
+		return c.name
+	}
+	return c.name + "=" + c.value
+}
+
+// capLSTokens returns the full set of CAP LS tokens, rendered with values
+// when ls302 is true (CAP LS 302 was requested). tlsAlready excludes the
+// "tls" capability, which is meaningless once the connection is already
+// secure.
+func capLSTokens(ls302, tlsAlready bool) []string {
+	tokens := make([]string, 0, len(serverCaps))
+	for _, c := range serverCaps {
+		if c.name == "tls" && tlsAlready {
+			continue
+		}
+		if ls302 {
+			tokens = append(tokens, capToken(c))
+		} else {
+			tokens = append(tokens, c.name)
+		}
+	}
+	return tokens
+}
+
+// capMultiline splits a CAP subcommand's token list into one-or-more
+// trailing strings no longer than 400 bytes, as recommended by IRCv3 for
+// servers advertising many capabilities.
+func capMultiline(tokens []string) []string {
+	const maxLineBytes = 400
+	var lines []string
+	var cur strings.Builder
+	for _, t := range tokens {
+		if cur.Len() > 0 && cur.Len()+1+len(t) > maxLineBytes {
+			lines = append(lines, cur.String())
+			cur.Reset()
+		}
+		if cur.Len() > 0 {
+			cur.WriteByte(' ')
+		}
+		cur.WriteString(t)
+	}
+	if cur.Len() > 0 || len(lines) == 0 {
+		lines = append(lines, cur.String())
+	}
+	return lines
+}
+
+// handleCapCommand processes one CAP line (LS, LIST, REQ or END) against the
+// shared negotiation state, sending whatever CAP replies the subcommand
+// requires.
+func handleCapCommand(cs *capNegState, stateCh chan state.State, conn connection, msg message) {
+	s := <-stateCh
+	defer func() { stateCh <- s }()
+
+	if len(msg.params) < 1 {
+		sendNumeric(s, conn.send, errorNeedMoreParams)
+		return
+	}
+
+	switch strings.ToUpper(msg.params[0]) {
+	case "LS":
+		cs.negotiating = true
+		cs.ended = false
+		if len(msg.params) > 1 && msg.params[1] == "302" {
+			cs.ls302 = true
+		}
+		for _, line := range capMultiline(capLSTokens(cs.ls302, conn.tlsConnectionState() != nil)) {
+			conn.send(message{command: "CAP", params: []string{"*", "LS"}, trailing: line})
+		}
+	case "LIST":
+		enabled := make([]string, 0, len(cs.caps))
+		for name := range cs.caps {
+			enabled = append(enabled, name)
+		}
+		conn.send(message{command: "CAP", params: []string{"*", "LIST"}, trailing: strings.Join(enabled, " ")})
+	case "REQ":
+		cs.negotiating = true
+		cs.ended = false
+		handleCapReq(cs, msg, conn)
+	case "END":
+		cs.ended = true
+	default:
+		sendNumeric(s, conn.send, errorUnknownCommand)
+	}
+}
+
+// handleCapReq resolves one atomic multi-capability REQ: either every token
+// is known and gets ACK'd, or the whole request is NAK'd unchanged.
+func handleCapReq(cs *capNegState, msg message, conn connection) {
+	requested := strings.Fields(msg.laxTrailing(1))
+	for _, tok := range requested {
+		name := strings.TrimPrefix(tok, "-")
+		if _, known := serverCaps[name]; !known {
+			conn.send(message{command: "CAP", params: []string{"*", "NAK"}, trailing: strings.Join(requested, " ")})
+			return
+		}
+	}
+
+	for _, tok := range requested {
+		name := strings.TrimPrefix(tok, "-")
+		c := serverCaps[name]
+		if strings.HasPrefix(tok, "-") {
+			delete(cs.caps, name)
+			if c.handler != nil {
+				c.handler.onDisable(cs)
+			}
+			if name == "message-tags" {
+				conn.setMessageTags(false)
+			}
+			continue
+		}
+		cs.caps[name] = struct{}{}
+		if c.handler != nil {
+			c.handler.onEnable(cs)
+		}
+		if name == "message-tags" {
+			conn.setMessageTags(true)
+		}
+	}
+	conn.send(message{command: "CAP", params: []string{"*", "ACK"}, trailing: strings.Join(requested, " ")})
+}
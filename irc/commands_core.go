@@ -0,0 +1,8 @@
+package irc
+
+// cmdPrivmsg and cmdNotice are the two message-delivery commands whose
+// trailing text is subject to line-length splitting.
+var (
+	cmdPrivmsg = message{command: "PRIVMSG"}
+	cmdNotice  = message{command: "NOTICE"}
+)
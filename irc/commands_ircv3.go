@@ -0,0 +1,5 @@
+package irc
+
+// cmdAuthenticate is the AUTHENTICATE command used during SASL negotiation,
+// per IRCv3 sasl-3.2.
+var cmdAuthenticate = message{command: "AUTHENTICATE"}
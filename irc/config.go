@@ -0,0 +1,75 @@
+package irc
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Config holds the server-wide settings used to construct connections and
+// handlers.
+type Config struct {
+	// Name is the server name advertised in message prefixes and PING payloads.
+	Name string
+
+	// PingFrequency is how often, in seconds, idle connections are pinged.
+	PingFrequency int
+
+	// PongMaxLatency is how long, in seconds, a client has to reply to a PING
+	// (or to send any line at all) before it is considered dead.
+	PongMaxLatency int
+
+	// SendLimit is the minimum interval between outbound lines released to a
+	// single connection's socket. Zero disables rate limiting.
+	SendLimit time.Duration
+
+	// SendBurst is the number of lines a connection may send back-to-back
+	// before SendLimit starts pacing it. Ignored when SendLimit is zero.
+	SendBurst int
+
+	// InboxHighWaterMark is the number of queued outbound messages a
+	// connection's inbox may hold before further sends are dropped rather
+	// than blocking the caller. Zero disables the mark: the inbox is then
+	// unbuffered and every send blocks until the write loop takes it.
+	InboxHighWaterMark int
+
+	// MaxLineBytes caps the serialized length, including "\r\n", of a single
+	// outbound line before it is split. Zero defaults to the standard
+	// 512-byte IRC limit; networks that negotiate IRCv3 longer lines may
+	// raise it.
+	MaxLineBytes int
+
+	// TLS, when non-nil, is used both to wrap a listener opened with
+	// TLSListen set and to service in-place STARTTLS upgrades. Client
+	// certificates are requested but not required, so the sasl EXTERNAL
+	// mechanism can authenticate from one when present.
+	TLS *tls.Config
+
+	// TLSListen selects whether Listen wraps the listener with TLS
+	// immediately, for networks that run implicit TLS ports instead of (or
+	// alongside) STARTTLS.
+	TLSListen bool
+
+	// ISupport overrides the computed RPL_ISUPPORT (005) defaults (e.g.
+	// NICKLEN, CHANMODES). Entries here win over the defaults; unset tokens
+	// fall back to them.
+	ISupport map[string]string
+
+	// Handlers, when non-nil, is dispatched alongside the state-machine
+	// handler chain for every message a connection receives, plus the
+	// synthesized CONNECTED/DISCONNECTED/REGISTER lifecycle events. Nil
+	// disables the event bus entirely. NewDefaultHandlers returns one
+	// preloaded with the built-in CTCP auto-replies. HandlerFunc takes the
+	// exported Conn/Event types, so importers can Register their own
+	// callbacks without forking package irc.
+	Handlers *Handlers
+}
+
+// NewDefaultHandlers returns a Handlers registry preloaded with the
+// built-ins every server gets for free: CTCP VERSION/PING/TIME auto-replies.
+// Callers are free to Register more, or to start from &Handlers{} instead
+// for a bus with no built-ins.
+func NewDefaultHandlers() *Handlers {
+	h := &Handlers{}
+	registerCTCPHandlers(h)
+	return h
+}
@@ -1,58 +1,318 @@
 package irc
 
 import (
+	"crypto/tls"
+	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// priorityBufferSize bounds the priority channel used for heartbeats and
+// other server-originated lines that must never wait on flood protection.
+const priorityBufferSize = 8
+
+// messageTagsReserveBytes is a conservative reservation for the IRCv3
+// message-tags framing (e.g. "@time=...;account=... " prefixed to the
+// line) a connection that negotiated message-tags may have added by the
+// time a line actually reaches the wire. It intentionally over-reserves
+// rather than risk a tagged line crossing the line budget.
+const messageTagsReserveBytes = 128
+
 // connection corresponds to some end-point that has connected to the IRC
 // server.
 type connection interface {
 	send(message)
 
+	// sendSplit behaves like send, but for PRIVMSG/NOTICE reserves tagBytes
+	// of the line budget (Config.MaxLineBytes, 512 by default) for IRCv3
+	// message tags before splitting
+	// oversize trailing text across multiple lines to the same target.
+	sendSplit(msg message, tagBytes int)
+
 	// loop reads messages from the connection and passes them to the handler.
 	loop()
 
 	// kill stops the execution of the go routine running Loop.
 	kill()
+
+	// startTLS upgrades the underlying socket to TLS in place, pausing
+	// writes for the duration of the handshake. Safe to call from within a
+	// handler running on the read loop's own goroutine.
+	startTLS(cfg *tls.Config) error
+
+	// tlsConnectionState reports the negotiated TLS state, or nil if the
+	// connection is not (yet) using TLS.
+	tlsConnectionState() *tls.ConnectionState
+
+	// setMessageTags records whether this connection negotiated the
+	// message-tags capability, so send reserves room for IRCv3 tags before
+	// splitting oversize PRIVMSG/NOTICE lines. See caps.go's handleCapReq.
+	setMessageTags(enabled bool)
+
+	// dispatchLifecycle fires any Config.Handlers callbacks registered
+	// against a synthesized lifecycle event (EventConnected/Disconnected/
+	// Registered), which never appears on the wire itself.
+	dispatchLifecycle(event string)
+
+	// connMetrics returns this connection's byte/message counters.
+	connMetrics() *ConnMetrics
 }
 
 type connectionImpl struct {
-	config    *Config
-	conn      net.Conn
-	handler   handler
+	config  *Config
+	handler handler
+
+	// connMu guards conn and connGen: startTLS takes the write lock for the
+	// duration of the handshake so writeLoop can't write plaintext and
+	// garble it, while readLoop only needs a read lock to snapshot the
+	// current conn between messages.
+	connMu      sync.RWMutex
+	conn        net.Conn
+	connGen     int
+	tlsState    *tls.ConnectionState
+	messageTags bool
+
+	// sendMu keeps the fragments of one sendSplit call contiguous in inbox.
+	sendMu sync.Mutex
+
 	inbox     chan message
+	priority  chan message // Heartbeats and other lines that bypass flood protection.
 	inject    chan message // Allows the connection to inject messages.
+	limiter   chan struct{}
 	gotPong   chan struct{}
 	killPing  chan struct{}
 	killRead  chan struct{}
 	killWrite chan struct{}
+	killLimit chan struct{}
+
+	// pool runs Config.Handlers callbacks off the read loop's own goroutine,
+	// so a slow registered callback delays other callbacks instead of
+	// stalling message processing. Nil when Config.Handlers is nil.
+	pool    *handlerPool
+	metrics *ConnMetrics
 }
 
 // newConnection creates a new connection with the given network connection and
 // handler.
 func newConnection(config *Config, conn net.Conn, handler handler) connection {
-	return &connectionImpl{
+	inboxSize := config.InboxHighWaterMark
+	c := &connectionImpl{
 		config:    config,
 		conn:      conn,
 		handler:   handler,
-		inbox:     make(chan message),
+		inbox:     make(chan message, inboxSize),
+		priority:  make(chan message, priorityBufferSize),
 		inject:    make(chan message, 1),
 		gotPong:   make(chan struct{}, 1),
 		killPing:  make(chan struct{}, 1),
 		killRead:  make(chan struct{}, 1),
 		killWrite: make(chan struct{}, 1),
+		killLimit: make(chan struct{}, 1),
+		metrics:   newConnMetrics(),
 	}
+
+	if config.SendLimit > 0 && config.SendBurst > 0 {
+		c.limiter = make(chan struct{}, config.SendBurst)
+		for i := 0; i < config.SendBurst; i++ {
+			c.limiter <- struct{}{}
+		}
+		go c.refillLimiter()
+	}
+
+	if config.Handlers != nil {
+		c.pool = newHandlerPool()
+	}
+
+	return c
 }
 
+// connMetrics returns this connection's byte/message counters.
+func (c *connectionImpl) connMetrics() *ConnMetrics {
+	return c.metrics
+}
+
+// runHandlers records msg in this connection's metrics, then dispatches it
+// to every matching Config.Handlers callback on the bounded pool.
+func (c *connectionImpl) runHandlers(msg message) {
+	c.metrics.record(msg)
+
+	if c.config.Handlers == nil {
+		return
+	}
+	evt := Event{Command: msg.command, Params: msg.params, Trailing: msg.trailing}
+	for _, fn := range c.config.Handlers.dispatch(msg) {
+		fn := fn
+		c.pool.submit(func() { fn(c, evt) })
+	}
+}
+
+// dispatchLifecycle fires Config.Handlers callbacks registered against a
+// synthesized lifecycle event; it does not touch connMetrics, since no
+// bytes were ever received for it.
+func (c *connectionImpl) dispatchLifecycle(event string) {
+	if c.config.Handlers == nil {
+		return
+	}
+	msg := message{command: event}
+	evt := Event{Command: event}
+	for _, fn := range c.config.Handlers.dispatch(msg) {
+		fn := fn
+		c.pool.submit(func() { fn(c, evt) })
+	}
+}
+
+// Send implements Conn by writing command/params/trailing back to this
+// connection as an ordinary outbound message.
+func (c *connectionImpl) Send(command string, params []string, trailing string) {
+	c.send(message{command: command, params: params, trailing: trailing})
+}
+
+// send queues msg for delivery, reserving message-tags room if this
+// connection negotiated it. See sendSplit for how oversize PRIVMSG/NOTICE
+// lines are handled.
 func (c *connectionImpl) send(msg message) {
-	c.inbox <- msg
+	c.sendSplit(msg, c.tagBytes())
+}
+
+// setMessageTags records whether this connection negotiated message-tags.
+func (c *connectionImpl) setMessageTags(enabled bool) {
+	c.connMu.Lock()
+	c.messageTags = enabled
+	c.connMu.Unlock()
+}
+
+// tagBytes is how many bytes of the line budget send reserves for IRCv3
+// tags, based on whether message-tags was negotiated.
+func (c *connectionImpl) tagBytes() int {
+	c.connMu.RLock()
+	enabled := c.messageTags
+	c.connMu.RUnlock()
+
+	if !enabled {
+		return 0
+	}
+	return messageTagsReserveBytes
+}
+
+// sendSplit queues msg for delivery, first splitting it into multiple lines
+// if its serialized form would exceed the connection's line budget. Every
+// fragment is enqueued here, in order, so nothing else to the same target
+// can interleave between them.
+func (c *connectionImpl) sendSplit(msg message, tagBytes int) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	for _, part := range splitMessage(msg, c.maxLineBytes(), tagBytes) {
+		c.enqueue(part)
+	}
+}
+
+// maxLineBytes returns the configured line-length budget, falling back to
+// the historical 512-byte IRC limit when unset.
+func (c *connectionImpl) maxLineBytes() int {
+	if c.config.MaxLineBytes > 0 {
+		return c.config.MaxLineBytes
+	}
+	return defaultMaxLineBytes
+}
+
+// enqueue applies the inbox high-water mark: once the inbox is full the
+// message is dropped and the client is warned instead of blocking the
+// caller (typically the state goroutine) indefinitely.
+func (c *connectionImpl) enqueue(msg message) {
+	if isPriorityCommand(msg.command) {
+		c.sendPriority(msg)
+		return
+	}
+
+	if cap(c.inbox) == 0 {
+		c.inbox <- msg
+		return
+	}
+
+	select {
+	case c.inbox <- msg:
+	default:
+		logrus.Warnf("inbox full, dropping message to slow connection: %+v", msg)
+		c.sendPriority(message{
+			command:  fmt.Sprintf("%03d", errorTooManyTargets.code),
+			params:   []string{"*"},
+			trailing: errorTooManyTargets.text,
+		})
+	}
+}
+
+// sendPriority queues msg on the priority channel, which the write loop
+// drains without waiting on flood-protection tokens, so heartbeats and
+// error replies are never delayed by a chatty or flooded connection.
+func (c *connectionImpl) sendPriority(msg message) {
+	select {
+	case c.priority <- msg:
+	default:
+		logrus.Warnf("priority channel full, dropping message: %+v", msg)
+	}
+}
+
+// snapshotConn returns the current underlying connection together with a
+// generation counter readLoop can use to notice that startTLS swapped it.
+func (c *connectionImpl) snapshotConn() (net.Conn, int) {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.conn, c.connGen
+}
+
+// closeConn closes whatever the current underlying connection is.
+func (c *connectionImpl) closeConn() {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	c.conn.Close()
+}
+
+// tlsConnectionState reports the negotiated TLS state, or nil if the
+// connection is not (yet) using TLS.
+func (c *connectionImpl) tlsConnectionState() *tls.ConnectionState {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.tlsState
+}
+
+// startTLS upgrades the connection to TLS in place. It holds the write lock
+// for the duration of the handshake, which blocks writeMessage (and Close
+// from the write/read loops) until the swap is complete, so the plaintext
+// and TLS streams never interleave on the wire.
+func (c *connectionImpl) startTLS(cfg *tls.Config) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	tlsConn := tls.Server(c.conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+
+	c.conn = tlsConn
+	c.connGen++
+	state := tlsConn.ConnectionState()
+	c.tlsState = &state
+	return nil
+}
+
+// isPriorityCommand reports whether msg must bypass flood protection:
+// PING/PONG/ERROR keep the connection alive and must never be delayed.
+func isPriorityCommand(cmd string) bool {
+	switch cmd {
+	case cmdPing.command, cmdPong.command, "ERROR":
+		return true
+	default:
+		return false
+	}
 }
 
 func (c *connectionImpl) loop() {
+	c.dispatchLifecycle(EventConnected)
 	go c.writeLoop()
 	go c.readLoop()
 	c.pingLoop()
@@ -63,12 +323,33 @@ func (c *connectionImpl) kill() {
 		c.killRead <- struct{}{}
 		c.killWrite <- struct{}{}
 		c.killPing <- struct{}{}
+		c.killLimit <- struct{}{}
 	}()
 }
 
+// refillLimiter releases one send token every SendLimit interval, up to the
+// SendBurst capacity, implementing a standard token-bucket rate limiter.
+func (c *connectionImpl) refillLimiter() {
+	ticker := time.NewTicker(c.config.SendLimit)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.killLimit:
+			return
+		case <-ticker.C:
+			select {
+			case c.limiter <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
 func (c *connectionImpl) readLoop() {
 	var msg message
-	parser := newMessageParser(c.conn)
+	conn, gen := c.snapshotConn()
+	parser := newMessageParser(conn)
 	readTimeout := time.Duration(c.config.PongMaxLatency) * time.Second
 
 	didQuit := false
@@ -82,7 +363,11 @@ func (c *connectionImpl) readLoop() {
 			didQuit = didQuit || msg.command == cmdQuit.command
 			c.handler = c.handler.handle(c, msg)
 		default:
-			c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+			if newConn, newGen := c.snapshotConn(); newGen != gen {
+				conn, gen = newConn, newGen
+				parser = newMessageParser(conn)
+			}
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
 			msg, hasMore = parser()
 			if msg.command == "" {
 				continue
@@ -98,10 +383,11 @@ func (c *connectionImpl) readLoop() {
 			didQuit = didQuit || msg.command == cmdQuit.command
 			logrus.Debugf("SEND MSG %v to handler ..", msg)
 			c.handler = c.handler.handle(c, msg)
+			c.runHandlers(msg)
 		}
 	}
 
-	c.conn.Close()
+	c.closeConn()
 
 	// If there was never a QUIT message then this is a premature termination and
 	// a quit message should be faked.
@@ -110,6 +396,12 @@ func (c *connectionImpl) readLoop() {
 		c.handler = c.handler.handle(c, cmdQuit.withTrailing("QUITing"))
 	}
 
+	c.dispatchLifecycle(EventDisconnected)
+	// The pool outlives kill so the DISCONNECTED callbacks above still run;
+	// nothing submits to it after this point.
+	if c.pool != nil {
+		c.pool.stop()
+	}
 	logrus.Debugln("Closing read loop.")
 }
 
@@ -119,24 +411,38 @@ func (c *connectionImpl) writeLoop() {
 		select {
 		case <-c.killWrite:
 			alive = false
+		case msg := <-c.priority:
+			c.writeMessage(msg)
 		case msg := <-c.inbox:
-			logrus.Debugf("send: %+v", msg)
-
-			line, ok := msg.toString()
-			if !ok {
-				break
-			}
-
-			_, err := io.WriteString(c.conn, line)
-			if err != nil {
-				logrus.Warnf("Error encountered sending message to client: %v", err)
-				// break
+			if c.limiter != nil {
+				<-c.limiter
 			}
+			c.writeMessage(msg)
 		}
 	}
 
 	logrus.Debugln("Closing write loop.")
-	c.conn.Close()
+	c.closeConn()
+}
+
+// writeMessage serializes and writes a single message to the socket. It
+// holds the connection's read lock so a concurrent startTLS handshake
+// always finishes swapping the connection before (or after), never during,
+// a write.
+func (c *connectionImpl) writeMessage(msg message) {
+	logrus.Debugf("send: %+v", msg)
+
+	line, ok := msg.toString()
+	if !ok {
+		return
+	}
+
+	c.connMu.RLock()
+	_, err := io.WriteString(c.conn, line)
+	c.connMu.RUnlock()
+	if err != nil {
+		logrus.Warnf("Error encountered sending message to client: %v", err)
+	}
 }
 
 func (c *connectionImpl) pingLoop() {
@@ -154,7 +460,7 @@ func (c *connectionImpl) pingLoop() {
 		case <-pongTimer:
 			c.inject <- cmdQuit.withTrailing("Timed out")
 		case <-time.After(pingDuration):
-			c.inbox <- cmdPing.withTrailing(c.config.Name)
+			c.sendPriority(cmdPing.withTrailing(c.config.Name))
 			pongTimer = time.After(pongDuration)
 		}
 	}
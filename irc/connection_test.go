@@ -0,0 +1,93 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestConnection builds a connectionImpl with no real socket, enough to
+// exercise enqueue/flood-protection logic directly.
+func newTestConnection(inboxSize int) *connectionImpl {
+	return &connectionImpl{
+		config:   &Config{},
+		inbox:    make(chan message, inboxSize),
+		priority: make(chan message, priorityBufferSize),
+		metrics:  newConnMetrics(),
+	}
+}
+
+func TestEnqueueDropsOnFullInbox(t *testing.T) {
+	c := newTestConnection(1)
+
+	c.enqueue(message{command: cmdPrivmsg.command, trailing: "first"})
+	c.enqueue(message{command: cmdPrivmsg.command, trailing: "second, drops"})
+
+	if len(c.inbox) != 1 {
+		t.Fatalf("inbox len = %d, want 1 (drop past the high-water mark)", len(c.inbox))
+	}
+	select {
+	case warning := <-c.priority:
+		if warning.command != "407" {
+			t.Errorf("priority warning command = %q, want 407 (errorTooManyTargets)", warning.command)
+		}
+	default:
+		t.Error("expected a priority warning when the inbox dropped a message")
+	}
+}
+
+func TestEnqueuePriorityCommandBypassesInbox(t *testing.T) {
+	c := newTestConnection(0)
+
+	c.enqueue(message{command: cmdPing.command})
+
+	select {
+	case msg := <-c.priority:
+		if msg.command != cmdPing.command {
+			t.Errorf("priority command = %q, want PING", msg.command)
+		}
+	default:
+		t.Error("expected PING to bypass the inbox onto the priority channel")
+	}
+}
+
+func TestRefillLimiterReplenishesUpToBurst(t *testing.T) {
+	c := &connectionImpl{
+		config:    &Config{SendLimit: time.Millisecond, SendBurst: 2},
+		limiter:   make(chan struct{}, 2),
+		killLimit: make(chan struct{}, 1),
+	}
+	go c.refillLimiter()
+	defer func() { c.killLimit <- struct{}{} }()
+
+	deadline := time.After(time.Second)
+	for len(c.limiter) < 2 {
+		select {
+		case <-deadline:
+			t.Fatalf("limiter len = %d after 1s, want 2 (refilled to SendBurst)", len(c.limiter))
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	// One further tick must not overflow the buffered channel past SendBurst.
+	time.Sleep(10 * time.Millisecond)
+	if len(c.limiter) != 2 {
+		t.Errorf("limiter len = %d, want 2 (capped at SendBurst, not overflowing)", len(c.limiter))
+	}
+}
+
+func TestIsPriorityCommand(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		want bool
+	}{
+		{cmdPing.command, true},
+		{cmdPong.command, true},
+		{"ERROR", true},
+		{cmdPrivmsg.command, false},
+	}
+	for _, c := range cases {
+		if got := isPriorityCommand(c.cmd); got != c.want {
+			t.Errorf("isPriorityCommand(%q) = %v, want %v", c.cmd, got, c.want)
+		}
+	}
+}
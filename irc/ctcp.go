@@ -0,0 +1,64 @@
+package irc
+
+import (
+	"strings"
+	"time"
+)
+
+// ctcpDelim wraps a CTCP request or reply inside an otherwise ordinary
+// PRIVMSG/NOTICE trailing parameter, per the long-standing (if never
+// formally RFC'd) CTCP convention.
+const ctcpDelim = "\x01"
+
+// registerCTCPHandlers wires the built-in CTCP auto-replies into handlers,
+// so any server using this package gets them without extra setup.
+func registerCTCPHandlers(handlers *Handlers) {
+	handlers.Register(cmdPrivmsg.command, ctcpAutoReply)
+}
+
+// ctcpAutoReply answers VERSION/PING/TIME CTCP requests. ACTION is
+// recognized but intentionally left unanswered: it is informational (/me),
+// not a request expecting a reply.
+func ctcpAutoReply(conn Conn, evt Event) {
+	verb, arg, ok := parseCTCP(evt.Trailing)
+	if !ok || len(evt.Params) == 0 {
+		return
+	}
+	target := evt.Params[0]
+
+	switch verb {
+	case "VERSION":
+		conn.Send(cmdNotice.command, []string{target}, ctcpBody("VERSION", "channels"))
+	case "PING":
+		conn.Send(cmdNotice.command, []string{target}, ctcpBody("PING", arg))
+	case "TIME":
+		conn.Send(cmdNotice.command, []string{target}, ctcpBody("TIME", time.Now().Format(time.RFC1123)))
+	}
+}
+
+// parseCTCP extracts the verb and argument from a CTCP-quoted trailing
+// parameter, e.g. "\x01PING 12345\x01" -> ("PING", "12345", true).
+func parseCTCP(trailing string) (verb, arg string, ok bool) {
+	if len(trailing) < 2 || !strings.HasPrefix(trailing, ctcpDelim) || !strings.HasSuffix(trailing, ctcpDelim) {
+		return "", "", false
+	}
+
+	body := trailing[1 : len(trailing)-1]
+	parts := strings.SplitN(body, " ", 2)
+	verb = strings.ToUpper(parts[0])
+	if len(parts) == 2 {
+		arg = parts[1]
+	}
+	return verb, arg, true
+}
+
+// ctcpBody renders a CTCP-quoted NOTICE trailing parameter, e.g.
+// ctcpBody("PING", "12345") -> "\x01PING 12345\x01".
+func ctcpBody(verb, arg string) string {
+	body := ctcpDelim + verb
+	if arg != "" {
+		body += " " + arg
+	}
+	body += ctcpDelim
+	return body
+}
@@ -0,0 +1,170 @@
+package irc
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Conn is the subset of a connection a HandlerFunc may use to reply,
+// exported so HandlerFunc can be implemented outside package irc.
+type Conn interface {
+	// Send writes a line back to this connection, reserving message-tags
+	// room and splitting oversize trailing text the same way the
+	// state-machine handlers' replies are.
+	Send(command string, params []string, trailing string)
+}
+
+// Event is the externally visible form of a dispatched message: the
+// command, parameters and trailing text a HandlerFunc can inspect. Lifecycle
+// events (EventConnected/Disconnected/Registered) carry Command set to the
+// event name and no params or trailing text.
+type Event struct {
+	Command  string
+	Params   []string
+	Trailing string
+}
+
+// HandlerFunc is a callback dispatched for a matching message, in addition
+// to (not instead of) the state-machine handler chain. It lets code
+// elsewhere in this package - or a built-in like the CTCP auto-replies in
+// ctcp.go - observe or react to traffic without forking a concrete handler.
+// It takes the exported Conn/Event types rather than the package-internal
+// connection/message ones, so external packages can register behavior
+// without forking package irc.
+type HandlerFunc func(conn Conn, evt Event)
+
+// HandlerToken identifies one registration so it can later be removed.
+type HandlerToken int
+
+// Synthesized lifecycle event names: these never appear on the wire, but
+// are dispatched through the same Handlers registry as real commands.
+const (
+	EventConnected    = "CONNECTED"
+	EventDisconnected = "DISCONNECTED"
+	EventRegistered   = "REGISTER"
+)
+
+// handlerPoolSize bounds how many registered callbacks run concurrently per
+// connection, so one slow callback can't starve the others - or, since it
+// runs off the read loop's own goroutine, stall message processing.
+const handlerPoolSize = 4
+
+// handlerJobBuffer bounds how many dispatched callbacks may be queued
+// before new ones are dropped rather than blocking the read loop.
+const handlerJobBuffer = 64
+
+type registration struct {
+	token HandlerToken
+	fn    HandlerFunc
+}
+
+// Handlers is an ordered, keyed registry of callbacks dispatched alongside
+// a connection's state-machine handler chain. The zero value is ready to
+// use; a single instance is normally shared across every connection a
+// server accepts.
+type Handlers struct {
+	mu    sync.Mutex
+	next  HandlerToken
+	byCmd map[string][]registration
+}
+
+// Register adds fn to run whenever a message matching cmd arrives. cmd may
+// be an exact command name (e.g. "PRIVMSG"), a numeric range like "4xx", or
+// "*" to match everything. The returned token can be passed to Remove.
+func (h *Handlers) Register(cmd string, fn HandlerFunc) HandlerToken {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.byCmd == nil {
+		h.byCmd = make(map[string][]registration)
+	}
+	h.next++
+	token := h.next
+	h.byCmd[cmd] = append(h.byCmd[cmd], registration{token: token, fn: fn})
+	return token
+}
+
+// Remove drops a previously registered callback. It is a no-op if the token
+// is unknown or was already removed.
+func (h *Handlers) Remove(token HandlerToken) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for cmd, regs := range h.byCmd {
+		for i, r := range regs {
+			if r.token == token {
+				h.byCmd[cmd] = append(regs[:i], regs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// dispatch returns every callback registered against msg's exact command,
+// its numeric range (e.g. "433" also matches "4xx"), and the "*" wildcard.
+func (h *Handlers) dispatch(msg message) []HandlerFunc {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var fns []HandlerFunc
+	for _, key := range []string{msg.command, numericRange(msg.command), "*"} {
+		if key == "" {
+			continue
+		}
+		for _, r := range h.byCmd[key] {
+			fns = append(fns, r.fn)
+		}
+	}
+	return fns
+}
+
+// numericRange maps a 3-digit numeric command to its wildcard range, e.g.
+// "433" -> "4xx", so one registration can match a whole class of replies.
+func numericRange(cmd string) string {
+	if len(cmd) != 3 {
+		return ""
+	}
+	for _, r := range cmd {
+		if r < '0' || r > '9' {
+			return ""
+		}
+	}
+	return string(cmd[0]) + "xx"
+}
+
+// handlerPool runs dispatched callbacks on a small fixed set of goroutines
+// per connection, decoupling a slow or misbehaving registered handler from
+// the read loop that feeds the state machine.
+type handlerPool struct {
+	jobs     chan func()
+	stopOnce sync.Once
+}
+
+func newHandlerPool() *handlerPool {
+	p := &handlerPool{jobs: make(chan func(), handlerJobBuffer)}
+	for i := 0; i < handlerPoolSize; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *handlerPool) worker() {
+	for job := range p.jobs {
+		job()
+	}
+}
+
+func (p *handlerPool) submit(job func()) {
+	select {
+	case p.jobs <- job:
+	default:
+		logrus.Warnf("handler pool saturated, dropping callback")
+	}
+}
+
+// stop closes the job queue, letting every worker goroutine drain and exit.
+// Safe to call more than once; only the first call has any effect.
+func (p *handlerPool) stop() {
+	p.stopOnce.Do(func() { close(p.jobs) })
+}
@@ -0,0 +1,53 @@
+package irc
+
+import "testing"
+
+func TestNumericRange(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		want string
+	}{
+		{"433", "4xx"},
+		{"001", "0xx"},
+		{"PRIVMSG", ""},
+		{"12", ""},
+		{"abc", ""},
+	}
+	for _, c := range cases {
+		if got := numericRange(c.cmd); got != c.want {
+			t.Errorf("numericRange(%q) = %q, want %q", c.cmd, got, c.want)
+		}
+	}
+}
+
+func TestHandlersDispatch(t *testing.T) {
+	h := &Handlers{}
+
+	var exact, wildcard, numRange int
+	tokExact := h.Register("PRIVMSG", func(Conn, Event) { exact++ })
+	h.Register("*", func(Conn, Event) { wildcard++ })
+	h.Register("4xx", func(Conn, Event) { numRange++ })
+
+	for _, fn := range h.dispatch(message{command: "PRIVMSG"}) {
+		fn(nil, Event{})
+	}
+	if exact != 1 || wildcard != 1 || numRange != 0 {
+		t.Fatalf("after PRIVMSG dispatch: exact=%d wildcard=%d numRange=%d, want 1,1,0", exact, wildcard, numRange)
+	}
+
+	for _, fn := range h.dispatch(message{command: "433"}) {
+		fn(nil, Event{})
+	}
+	if numRange != 1 || wildcard != 2 {
+		t.Fatalf("after 433 dispatch: numRange=%d wildcard=%d, want 1,2", numRange, wildcard)
+	}
+
+	h.Remove(tokExact)
+	exact = 0
+	for _, fn := range h.dispatch(message{command: "PRIVMSG"}) {
+		fn(nil, Event{})
+	}
+	if exact != 0 {
+		t.Errorf("exact callback still fired after Remove: %d", exact)
+	}
+}
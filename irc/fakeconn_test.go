@@ -0,0 +1,28 @@
+package irc
+
+import (
+	"crypto/tls"
+)
+
+// fakeConn is a minimal connection double that records every message
+// handed to send/sendSplit, for tests that exercise handler logic without
+// a real socket.
+type fakeConn struct {
+	sent      []message
+	lifecycle []string
+}
+
+func (f *fakeConn) send(msg message)                         { f.sent = append(f.sent, msg) }
+func (f *fakeConn) sendSplit(msg message, tagBytes int)      { f.sent = append(f.sent, msg) }
+func (f *fakeConn) loop()                                    {}
+func (f *fakeConn) kill()                                    {}
+func (f *fakeConn) startTLS(cfg *tls.Config) error           { return nil }
+func (f *fakeConn) tlsConnectionState() *tls.ConnectionState { return nil }
+func (f *fakeConn) setMessageTags(enabled bool)              {}
+func (f *fakeConn) dispatchLifecycle(event string)           { f.lifecycle = append(f.lifecycle, event) }
+func (f *fakeConn) connMetrics() *ConnMetrics                { return newConnMetrics() }
+
+// Send implements Conn, so fakeConn also doubles for HandlerFunc tests.
+func (f *fakeConn) Send(command string, params []string, trailing string) {
+	f.sent = append(f.sent, message{command: command, params: params, trailing: trailing})
+}
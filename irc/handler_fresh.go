@@ -9,15 +9,23 @@ import (
 // freshHandler is a handler for a brand new connection that has not been
 // registered yet.
 type freshHandler struct {
-	state       chan state.State
-	pass        string
-	v3CapClient bool
-	capEnd      bool
-	caps        map[string]struct{}
+	config   *Config
+	state    chan state.State
+	pass     string
+	capState *capNegState
+
+	// nick is the nickname from the most recent NICK line. nickSeen is true
+	// once one has arrived; claiming it against state may be deferred past
+	// that point if sasl was requested but hasn't completed yet, since the
+	// standard CAP REQ :sasl -> NICK/USER -> AUTHENTICATE ordering sends
+	// NICK well before SASL, and often without PASS at all. See handleNick
+	// and maybeClaimNick.
+	nick     string
+	nickSeen bool
 }
 
-func newFreshHandler(s chan state.State) handler {
-	return &freshHandler{state: s, caps: make(map[string]struct{})}
+func newFreshHandler(config *Config, s chan state.State) handler {
+	return &freshHandler{config: config, state: s, capState: newCapNegState()}
 }
 
 func (h *freshHandler) handle(conn connection, msg message) handler {
@@ -31,7 +39,14 @@ func (h *freshHandler) handle(conn connection, msg message) handler {
 	case cmdPass.command:
 		return h.handlePass(conn, msg)
 	case cmdCap.command:
-		return h.handleCap(conn, msg)
+		handleCapCommand(h.capState, h.state, conn, msg)
+		return h.maybeClaimNick(conn)
+	case cmdAuthenticate.command:
+		handleAuthenticateCommand(h.capState, h.state, conn, msg)
+		return h.maybeClaimNick(conn)
+	case cmdStarttls.command:
+		handleStarttlsCommand(h.config, h.state, conn, msg)
+		return h
 	default:
 		return h
 	}
@@ -41,21 +56,6 @@ func (_ *freshHandler) closed(c connection) {
 	c.kill()
 }
 
-func (h *freshHandler) handleCap(conn connection, msg message) handler {
-	s := <-h.state
-	defer func() { h.state <- s }()
-
-	if len(msg.params) < 1 {
-		sendNumeric(s, conn.send, errorNeedMoreParams)
-	} else {
-		logrus.Debugf("get msg: %v", msg)
-		h.v3CapClient = true
-		h.capEnd = true
-		h.caps["message-tag"] = struct{}{}
-	}
-	return h
-}
-
 func (h *freshHandler) handlePass(conn connection, msg message) handler {
 	s := <-h.state
 	defer func() { h.state <- s }()
@@ -77,18 +77,55 @@ func (h *freshHandler) handleNick(conn connection, msg message) handler {
 		return h
 	}
 	nick := msg.params[0]
-	if h.pass == "" {
-		sendNumeric(s, conn.send, errorPasswdMismatch)
+	if max, ok := h.config.GetServerOptionInt(isupportNicklen); ok && len(nick) > max {
+		sendNumeric(s, conn.send, errorErroneousNickname)
 		return h
 	}
 
-	caller, err := s.Auth(nick, h.pass)
-	if err != nil {
-		logrus.Debugf("login failed %s: %v", nick, err)
-		sendNumeric(s, conn.send, errorPasswdMismatch)
+	h.nick = nick
+	h.nickSeen = true
+	if h.capState.blocksRegistration() {
+		// Requested sasl but hasn't completed it; claim the nick once
+		// AUTHENTICATE succeeds or the cap is dropped. See maybeClaimNick.
 		return h
 	}
-	if caller.Name != nick {
+
+	return h.claimNick(s, conn)
+}
+
+// maybeClaimNick retries claimNick once a CAP or AUTHENTICATE line may have
+// unblocked a nick claim deferred by handleNick.
+func (h *freshHandler) maybeClaimNick(conn connection) handler {
+	if !h.nickSeen || h.capState.blocksRegistration() {
+		return h
+	}
+
+	s := <-h.state
+	defer func() { h.state <- s }()
+
+	return h.claimNick(s, conn)
+}
+
+// claimNick authenticates h.nick, either against the already-completed sasl
+// caller or (failing that) PASS, and claims it against state.
+func (h *freshHandler) claimNick(s state.State, conn connection) handler {
+	nick := h.nick
+	caller := h.capState.saslCaller
+	if caller == nil {
+		if h.pass == "" {
+			sendNumeric(s, conn.send, errorPasswdMismatch)
+			return h
+		}
+
+		var err error
+		caller, err = s.Auth(nick, h.pass)
+		if err != nil {
+			logrus.Debugf("login failed %s: %v", nick, err)
+			sendNumeric(s, conn.send, errorPasswdMismatch)
+			return h
+		}
+	}
+	if h.config.foldCase(caller.Name) != h.config.foldCase(nick) {
 		sendNumeric(s, conn.send, errorNickCollision)
 		return h
 	}
@@ -99,21 +136,28 @@ func (h *freshHandler) handleNick(conn connection, msg message) handler {
 		return h
 	}
 
-	if h.capEnd {
-		s.SetUserCap(user, capMsgTag)
+	for name := range h.capState.caps {
+		s.SetUserCap(user, name)
 	}
 
 	user.AddRoles(caller.Roles...)
 	user.SetSendFn(messageSink(conn, user.GetCaps()))
 
-	return &freshUserHandler{state: h.state, user: user}
+	return &freshUserHandler{config: h.config, state: h.state, user: user, capState: h.capState}
 }
 
 // freshUserHandler is a handler for a brand new connection that is in the
 // process of registering and has successfully set a nickname.
 type freshUserHandler struct {
-	user  *state.User
-	state chan state.State
+	config   *Config
+	user     *state.User
+	state    chan state.State
+	capState *capNegState
+
+	// userSeen is true once USER has been parsed successfully. Registration
+	// only completes once userSeen is true and CAP negotiation, if any, has
+	// ended.
+	userSeen bool
 }
 
 func (h *freshUserHandler) handle(conn connection, msg message) handler {
@@ -124,10 +168,21 @@ func (h *freshUserHandler) handle(conn connection, msg message) handler {
 		conn.kill()
 		return nullHandler{}
 	}
-	if msg.command != cmdUser.command {
+	switch msg.command {
+	case cmdUser.command:
+		return h.handleUser(conn, msg)
+	case cmdCap.command:
+		handleCapCommand(h.capState, h.state, conn, msg)
+		return h.maybeFinishRegistration(conn)
+	case cmdAuthenticate.command:
+		handleAuthenticateCommand(h.capState, h.state, conn, msg)
+		return h.maybeFinishRegistration(conn)
+	case cmdStarttls.command:
+		handleStarttlsCommand(h.config, h.state, conn, msg)
+		return h
+	default:
 		return h
 	}
-	return h.handleUser(conn, msg)
 }
 
 func (h *freshUserHandler) closed(c connection) {
@@ -148,7 +203,36 @@ func (h *freshUserHandler) handleUser(conn connection, msg message) handler {
 		return h
 	}
 
+	h.userSeen = true
+	if h.capState.negotiating && !h.capState.ended {
+		// Registration completes once CAP END arrives; see handle().
+		return h
+	}
+	if h.capState.blocksRegistration() {
+		// Requested sasl but hasn't completed it; see blocksRegistration.
+		sendNumericUser(s, h.user, conn.send, errorSaslFail)
+		return h
+	}
+
 	sendIntro(s, h.user, conn.send)
+	sendISupport(s, h.user, conn.send, h.config)
+	conn.dispatchLifecycle(EventRegistered)
+	return newUserHandler(h.state, h.user.GetName())
+}
+
+// maybeFinishRegistration completes registration once USER has been seen,
+// CAP negotiation has ended, and sasl (if requested) has completed, which
+// may all happen in any order.
+func (h *freshUserHandler) maybeFinishRegistration(conn connection) handler {
+	if !h.userSeen || !h.capState.ended || h.capState.blocksRegistration() {
+		return h
+	}
 
+	s := <-h.state
+	defer func() { h.state <- s }()
+
+	sendIntro(s, h.user, conn.send)
+	sendISupport(s, h.user, conn.send, h.config)
+	conn.dispatchLifecycle(EventRegistered)
 	return newUserHandler(h.state, h.user.GetName())
 }
@@ -0,0 +1,201 @@
+package irc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"channels/state"
+)
+
+// ISUPPORT token names, per the de-facto RPL_ISUPPORT (005) conventions.
+const (
+	isupportCasemapping = "CASEMAPPING"
+	isupportPrefix      = "PREFIX"
+	isupportChanmodes   = "CHANMODES"
+	isupportNetwork     = "NETWORK"
+	isupportChantypes   = "CHANTYPES"
+	isupportNicklen     = "NICKLEN"
+	isupportChannellen  = "CHANNELLEN"
+	isupportTopiclen    = "TOPICLEN"
+	isupportAwaylen     = "AWAYLEN"
+	isupportKicklen     = "KICKLEN"
+	isupportMaxtargets  = "MAXTARGETS"
+)
+
+// isupportTokensPerLine is the maximum number of ISUPPORT tokens per 005
+// line most clients expect a server to stick to.
+const isupportTokensPerLine = 13
+
+// defaultISupport computes this server's ISUPPORT values before Config's
+// overrides are merged in.
+func defaultISupport(config *Config) map[string]string {
+	return map[string]string{
+		isupportCasemapping: "rfc1459",
+		isupportPrefix:      "(ov)@+",
+		isupportChanmodes:   "b,k,l,imnpst",
+		isupportNetwork:     config.Name,
+		isupportChantypes:   "#",
+		isupportNicklen:     "30",
+		isupportChannellen:  "64",
+		isupportTopiclen:    "390",
+		isupportAwaylen:     "200",
+		isupportKicklen:     "180",
+		isupportMaxtargets:  "4",
+	}
+}
+
+// isupportValues returns the merged, final ISUPPORT values: Config.ISupport
+// entries win over the computed defaults.
+func isupportValues(config *Config) map[string]string {
+	values := defaultISupport(config)
+	for k, v := range config.ISupport {
+		values[k] = v
+	}
+	return values
+}
+
+// isupportTokens renders isupportValues as NAME=VALUE tokens (or bare NAME
+// for valueless ones), sorted so repeated bursts are byte-identical.
+func isupportTokens(config *Config) []string {
+	values := isupportValues(config)
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tokens := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if values[k] == "" {
+			tokens = append(tokens, k)
+			continue
+		}
+		tokens = append(tokens, k+"="+values[k])
+	}
+	return tokens
+}
+
+// sendISupport sends one or more RPL_ISUPPORT (005) numerics advertising
+// this server's limits and modes, split at isupportTokensPerLine tokens per
+// line.
+func sendISupport(s state.State, u *state.User, send func(message), config *Config) {
+	tokens := isupportTokens(config)
+
+	for i := 0; i < len(tokens); i += isupportTokensPerLine {
+		end := i + isupportTokensPerLine
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		params := append([]string{u.GetName()}, tokens[i:end]...)
+		send(message{command: "005", params: params, trailing: "are supported by this server"})
+	}
+}
+
+// GetServerOption returns the advertised ISUPPORT value for name, so
+// handlers consult the same values clients were told rather than
+// hardcoding limits separately.
+func (c *Config) GetServerOption(name string) (string, bool) {
+	v, ok := isupportValues(c)[strings.ToUpper(name)]
+	return v, ok
+}
+
+// GetServerOptionInt is GetServerOption for numeric ISUPPORT values such as
+// NICKLEN or MAXTARGETS.
+func (c *Config) GetServerOptionInt(name string) (int, bool) {
+	v, ok := c.GetServerOption(name)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// foldCase normalizes a nick or channel name for lookups/comparisons under
+// the server's advertised CASEMAPPING. Only rfc1459 and ascii are
+// recognised; anything else is left unfolded.
+func (c *Config) foldCase(name string) string {
+	mapping, _ := c.GetServerOption(isupportCasemapping)
+	switch mapping {
+	case "ascii":
+		return strings.ToLower(name)
+	case "rfc1459", "":
+		return rfc1459Fold(name)
+	default:
+		return name
+	}
+}
+
+// rfc1459Fold lowercases a string using the rfc1459 casemapping, which also
+// folds {}|^ onto []\~.
+func rfc1459Fold(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch r {
+		case '{':
+			r = '['
+		case '}':
+			r = ']'
+		case '|':
+			r = '\\'
+		case '^':
+			r = '~'
+		default:
+			if r >= 'A' && r <= 'Z' {
+				r += 'a' - 'A'
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// truncateTopic shortens a topic to the advertised TOPICLEN.
+//
+// Unwired, and not wireable from within this tree as it stands: there is no
+// TOPIC handler to call it from, and none can be added here without also
+// inventing the handler/message/numeric core this package's own files only
+// ever reference (handler, message, numeric - see handler_fresh.go,
+// connection.go, numerics_*.go), never define. Enforcing TOPICLEN is a
+// scope dependency on that core landing first, not a gap this fix can close
+// in isolation; whatever adds the core and the TOPIC handler should call
+// this before storing/relaying the new topic, rather than enforcing
+// TOPICLEN separately.
+func (c *Config) truncateTopic(topic string) string {
+	max, ok := c.GetServerOptionInt(isupportTopiclen)
+	if !ok || len(topic) <= max {
+		return topic
+	}
+	return topic[:max]
+}
+
+// splitTargets splits a comma-separated PRIVMSG/NOTICE target list into
+// batches no longer than the advertised MAXTARGETS.
+//
+// Unwired, for the same reason as truncateTopic: there is no PRIVMSG/NOTICE
+// handler in this tree, and the handler/message/numeric core it would need
+// is itself never defined here (see truncateTopic's doc). Whatever adds
+// that core and the PRIVMSG handler should batch a multi-target send
+// through this first, rather than enforcing MAXTARGETS separately.
+func (c *Config) splitTargets(targets []string) [][]string {
+	max, ok := c.GetServerOptionInt(isupportMaxtargets)
+	if !ok || max <= 0 {
+		return [][]string{targets}
+	}
+
+	var batches [][]string
+	for len(targets) > 0 {
+		end := max
+		if end > len(targets) {
+			end = len(targets)
+		}
+		batches = append(batches, targets[:end])
+		targets = targets[end:]
+	}
+	return batches
+}
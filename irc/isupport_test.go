@@ -0,0 +1,62 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRfc1459Fold(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"Alice", "alice"},
+		{"Alice{}|^", "alice[]\\~"},
+		{"", ""},
+		{"already-lower", "already-lower"},
+	}
+
+	for _, c := range cases {
+		if got := rfc1459Fold(c.in); got != c.want {
+			t.Errorf("rfc1459Fold(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestIsupportTokens(t *testing.T) {
+	config := &Config{Name: "test.network"}
+
+	got := isupportTokens(config)
+	if len(got) == 0 {
+		t.Fatal("isupportTokens returned no tokens")
+	}
+
+	for i := 1; i < len(got); i++ {
+		if got[i-1] > got[i] {
+			t.Fatalf("isupportTokens not sorted: %q before %q", got[i-1], got[i])
+		}
+	}
+
+	joined := strings.Join(got, " ")
+	if !strings.Contains(joined, "NETWORK=test.network") {
+		t.Errorf("isupportTokens missing NETWORK=test.network, got %v", got)
+	}
+
+	overridden := &Config{Name: "test.network", ISupport: map[string]string{isupportNicklen: "16"}}
+	got = isupportTokens(overridden)
+	if !strings.Contains(strings.Join(got, " "), "NICKLEN=16") {
+		t.Errorf("isupportTokens didn't honor Config.ISupport override, got %v", got)
+	}
+}
+
+func TestGetServerOptionInt(t *testing.T) {
+	config := &Config{ISupport: map[string]string{isupportNicklen: "20"}}
+
+	n, ok := config.GetServerOptionInt(isupportNicklen)
+	if !ok || n != 20 {
+		t.Errorf("GetServerOptionInt(NICKLEN) = %d, %v, want 20, true", n, ok)
+	}
+
+	if _, ok := config.GetServerOptionInt("NOSUCHTOKEN"); ok {
+		t.Error("GetServerOptionInt(NOSUCHTOKEN) reported ok for a missing token")
+	}
+}
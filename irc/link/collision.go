@@ -0,0 +1,14 @@
+package link
+
+// ResolveCollision implements TS6's "lower timestamp wins" rule for a nick
+// or channel claim seen with conflicting origins: it reports whether the
+// local claim (localTS, localSID) should survive a collision against a
+// remote one (remoteTS, remoteSID). Lower timestamp wins; a tie is broken
+// by comparing SIDs lexicographically, so exactly one side of the link
+// defers instead of both (or neither) applying the remote rule symmetrically.
+func ResolveCollision(localTS, remoteTS int64, localSID, remoteSID string) bool {
+	if localTS != remoteTS {
+		return localTS < remoteTS
+	}
+	return localSID < remoteSID
+}
@@ -0,0 +1,37 @@
+package link
+
+import "testing"
+
+func TestResolveCollision(t *testing.T) {
+	cases := []struct {
+		name                string
+		localTS, remoteTS   int64
+		localSID, remoteSID string
+		wantLocalSurvives   bool
+	}{
+		{"local strictly lower wins", 100, 200, "1AA", "1AB", true},
+		{"remote strictly lower wins", 200, 100, "1AA", "1AB", false},
+		{"tie broken by lower sid, local lower", 100, 100, "1AA", "1AB", true},
+		{"tie broken by lower sid, remote lower", 100, 100, "1AB", "1AA", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ResolveCollision(c.localTS, c.remoteTS, c.localSID, c.remoteSID)
+			if got != c.wantLocalSurvives {
+				t.Errorf("ResolveCollision(%d, %d, %q, %q) = %v, want %v",
+					c.localTS, c.remoteTS, c.localSID, c.remoteSID, got, c.wantLocalSurvives)
+			}
+
+			// A tie must resolve oppositely from the other side's point of
+			// view, or neither/both sides would defer - the split-brain bug
+			// this test guards against.
+			if c.localTS == c.remoteTS {
+				otherView := ResolveCollision(c.remoteTS, c.localTS, c.remoteSID, c.localSID)
+				if otherView == got {
+					t.Errorf("tie resolves the same way from both sides: got %v both times", got)
+				}
+			}
+		})
+	}
+}
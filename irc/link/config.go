@@ -0,0 +1,36 @@
+package link
+
+import "time"
+
+// PeerConfig describes one configured peer to dial at startup.
+type PeerConfig struct {
+	// Name is the peer's server name, checked against its SERVER line.
+	Name string
+
+	// Addr is the "host:port" to dial.
+	Addr string
+}
+
+// Config holds the settings for this node's side of the S2S mesh.
+type Config struct {
+	// SID is this server's unique TS6-style server ID, e.g. "1AB".
+	SID string
+
+	// Name is this server's name, sent in the SERVER line.
+	Name string
+
+	// LinkPassword is the shared secret both sides of a link must present
+	// in their PASS line.
+	LinkPassword string
+
+	// Peers are dialed on startup and redialed with backoff on failure.
+	Peers []PeerConfig
+
+	// PingFrequency is how often idle peer links are pinged.
+	PingFrequency time.Duration
+
+	// DialBackoffMin/Max bound the exponential backoff between reconnect
+	// attempts to a configured peer.
+	DialBackoffMin time.Duration
+	DialBackoffMax time.Duration
+}
@@ -0,0 +1,271 @@
+package link
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"channels/state"
+)
+
+// RemoteEvent is a peer command translated into the shape state.ApplyRemote
+// expects: enough to apply the effect locally without re-broadcasting it.
+type RemoteEvent struct {
+	// Origin is the SID of the server the event originated from, used to
+	// avoid echoing it back to where it came from.
+	Origin string
+
+	Command string
+	Params  []string
+}
+
+// Hub owns every peer link this node has, inbound or outbound, and routes
+// local events out to them and remote events into the local state.
+type Hub struct {
+	config Config
+	state  chan state.State
+
+	mu     sync.Mutex
+	peers  map[string]*peerConn       // keyed by peer SID once known, by addr before.
+	claims map[string]collisionRecord // keyed by "nick:<name>" / "chan:<name>".
+}
+
+// collisionRecord is the origin timestamp and SID behind a claimed nick or
+// channel name, tracked so a later conflicting UID/SJOIN from another peer
+// can be resolved with ResolveCollision instead of blindly applied.
+type collisionRecord struct {
+	ts  int64
+	sid string
+}
+
+// NewHub creates a Hub that will apply remote events into stateCh.
+func NewHub(config Config, stateCh chan state.State) *Hub {
+	return &Hub{config: config, state: stateCh, peers: make(map[string]*peerConn), claims: make(map[string]collisionRecord)}
+}
+
+// ListenAndServe accepts inbound peer connections on addr until it fails or
+// the listener is closed.
+func (h *Hub) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go h.acceptPeer(conn)
+	}
+}
+
+func (h *Hub) acceptPeer(conn net.Conn) {
+	p := newPeerConn(h, conn, &peerHandshakeHandler{})
+	p.loop()
+}
+
+// DialPeers dials every configured peer in the background, redialing with
+// exponential backoff on failure or disconnect.
+func (h *Hub) DialPeers() {
+	for _, peer := range h.config.Peers {
+		go h.dialLoop(peer)
+	}
+}
+
+func (h *Hub) dialLoop(peer PeerConfig) {
+	backoff := h.config.DialBackoffMin
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	max := h.config.DialBackoffMax
+	if max <= 0 {
+		max = 2 * time.Minute
+	}
+
+	for {
+		conn, err := net.Dial("tcp", peer.Addr)
+		if err != nil {
+			logrus.Warnf("link: dial %s (%s) failed: %v, retrying in %s", peer.Name, peer.Addr, err, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > max {
+				backoff = max
+			}
+			continue
+		}
+
+		backoff = h.config.DialBackoffMin
+		if backoff <= 0 {
+			backoff = time.Second
+		}
+
+		p := newPeerConn(h, conn, &peerHandshakeHandler{})
+		p.send(peerMessage{command: "PASS", params: []string{h.config.LinkPassword, "TS", "6", h.config.SID}})
+		p.send(peerMessage{command: "SERVER", params: []string{h.config.Name, "1", h.config.Name}})
+		p.loop()
+
+		logrus.Warnf("link: lost peer %s, redialing in %s", peer.Name, backoff)
+		time.Sleep(backoff)
+	}
+}
+
+func (h *Hub) registerPeer(p *peerConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.peers[p.name] = p
+}
+
+func (h *Hub) unregisterPeer(p *peerConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.peers[p.name] == p {
+		delete(h.peers, p.name)
+	}
+}
+
+// applyRemote turns one inbound peer line into a RemoteEvent and applies it
+// to local state, then rebroadcasts it on to every other peer so a 3+ node
+// mesh propagates events past a single hop. UID and SJOIN are first checked
+// against admitRemote, so a losing side of a nick/channel collision is
+// dropped instead of applied and rebroadcast.
+func (h *Hub) applyRemote(from *peerConn, msg peerMessage) {
+	if !h.admitRemote(from, msg) {
+		return
+	}
+
+	event := RemoteEvent{Origin: from.sid, Command: msg.command, Params: msg.params}
+
+	s := <-h.state
+	s.ApplyRemote(event)
+	h.state <- s
+
+	h.broadcastExcept(from.name, msg)
+}
+
+// claimKey extracts the collision key and timestamp a UID ("UID <nick>
+// <hopcount> <nickTS> ...") or SJOIN ("SJOIN <chanTS> <chan> ...") line
+// claims, shared between admitRemote (checking a peer's claim) and
+// claimLocal (recording this node's own). ok is false for any other
+// command, or a UID/SJOIN line too short or malformed to parse.
+func claimKey(command string, params []string) (key string, ts int64, ok bool) {
+	switch command {
+	case "UID":
+		if len(params) < 3 {
+			return "", 0, false
+		}
+		t, err := strconv.ParseInt(params[2], 10, 64)
+		if err != nil {
+			return "", 0, false
+		}
+		return "nick:" + params[0], t, true
+	case "SJOIN":
+		if len(params) < 2 {
+			return "", 0, false
+		}
+		t, err := strconv.ParseInt(params[0], 10, 64)
+		if err != nil {
+			return "", 0, false
+		}
+		return "chan:" + params[1], t, true
+	default:
+		return "", 0, false
+	}
+}
+
+// admitRemote resolves nick/channel claim collisions for UID/SJOIN lines
+// using ResolveCollision's lower-timestamp-wins rule, against whatever
+// claim (local or from an earlier remote line) this node already holds for
+// the same key. It reports false when the incoming claim loses and should
+// be dropped rather than applied. Commands other than UID/SJOIN, or lines
+// too short to carry a timestamp, always pass through unchanged.
+func (h *Hub) admitRemote(from *peerConn, msg peerMessage) bool {
+	key, ts, ok := claimKey(msg.command, msg.params)
+	if !ok {
+		return true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if existing, seen := h.claims[key]; seen && ResolveCollision(existing.ts, ts, existing.sid, from.sid) {
+		logrus.Warnf("link: dropping %s for %s: loses collision against existing claim from %s", msg.command, key, existing.sid)
+		return false
+	}
+
+	h.claims[key] = collisionRecord{ts: ts, sid: from.sid}
+	return true
+}
+
+// claimLocal records this node's own claim for a local UID/SJOIN event
+// under this node's SID, the same way admitRemote does for a peer's, so
+// that a node linking in later and bursting a conflicting claim is
+// correctly resolved by admitRemote instead of blindly admitted.
+func (h *Hub) claimLocal(command string, params []string) {
+	key, ts, ok := claimKey(command, params)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.claims[key] = collisionRecord{ts: ts, sid: h.config.SID}
+}
+
+// Broadcast rebroadcasts a local event (UID/SJOIN/PRIVMSG/JOIN/PART/QUIT/
+// NICK/MODE/TOPIC) to every peer, tagged with this server's SID as the
+// origin. UID and SJOIN also record a local claim via claimLocal, so a
+// peer linking in afterward and bursting a conflicting claim is resolved
+// by admitRemote instead of blindly admitted.
+func (h *Hub) Broadcast(command string, params []string) {
+	h.claimLocal(command, params)
+	h.broadcastExcept("", peerMessage{command: command, params: append([]string{h.config.SID}, params...)})
+}
+
+// BurstTo sends every local claim this node currently holds - the nicks and
+// channels introduced via Broadcast's UID/SJOIN calls - to a single
+// newly-established peer, as UID and SJOIN lines carrying just the
+// nick/channel name and claim timestamp. That's the full extent of what
+// this claims registry models; a real TS6 burst would also carry user@host,
+// ip, umodes and channel membership with mode prefixes, but this tree has
+// no user-profile or channel-membership state to source those fields from.
+func (h *Hub) BurstTo(p *peerConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, rec := range h.claims {
+		if rec.sid != h.config.SID {
+			continue // only this node's own claims are ours to burst
+		}
+		switch {
+		case strings.HasPrefix(key, "nick:"):
+			nick := strings.TrimPrefix(key, "nick:")
+			p.send(peerMessage{command: "UID", params: []string{nick, "1", strconv.FormatInt(rec.ts, 10)}})
+		case strings.HasPrefix(key, "chan:"):
+			chanName := strings.TrimPrefix(key, "chan:")
+			p.send(peerMessage{command: "SJOIN", params: []string{strconv.FormatInt(rec.ts, 10), chanName}})
+		}
+	}
+}
+
+func (h *Hub) broadcastExcept(exceptPeer string, msg peerMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for name, p := range h.peers {
+		if name == exceptPeer {
+			continue
+		}
+		p.send(msg)
+	}
+}
+
+// String is used in log messages identifying this hub's node.
+func (h *Hub) String() string {
+	return fmt.Sprintf("%s(%s)", h.config.Name, h.config.SID)
+}
@@ -0,0 +1,59 @@
+package link
+
+import (
+	"testing"
+
+	"channels/state"
+)
+
+func TestClaimKey(t *testing.T) {
+	cases := []struct {
+		name    string
+		command string
+		params  []string
+		wantKey string
+		wantTS  int64
+		wantOK  bool
+	}{
+		{"UID", "UID", []string{"alice", "1", "100"}, "nick:alice", 100, true},
+		{"UID too short", "UID", []string{"alice"}, "", 0, false},
+		{"UID bad ts", "UID", []string{"alice", "1", "not-a-number"}, "", 0, false},
+		{"SJOIN", "SJOIN", []string{"200", "#chan"}, "chan:#chan", 200, true},
+		{"SJOIN too short", "SJOIN", []string{"200"}, "", 0, false},
+		{"other command", "PRIVMSG", []string{"#chan", "hi"}, "", 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key, ts, ok := claimKey(c.command, c.params)
+			if ok != c.wantOK {
+				t.Fatalf("claimKey(%q, %v) ok = %v, want %v", c.command, c.params, ok, c.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if key != c.wantKey || ts != c.wantTS {
+				t.Errorf("claimKey(%q, %v) = (%q, %d), want (%q, %d)", c.command, c.params, key, ts, c.wantKey, c.wantTS)
+			}
+		})
+	}
+}
+
+// TestHubClaimLocalDefendsAgainstRemote guards against the split-brain bug
+// where a locally-registered nick was never recorded as a claim, so a peer
+// linking in afterward and bursting its own conflicting claim was admitted
+// unconditionally instead of being checked by admitRemote.
+func TestHubClaimLocalDefendsAgainstRemote(t *testing.T) {
+	h := NewHub(Config{SID: "1AA"}, make(chan state.State))
+	h.claimLocal("UID", []string{"alice", "1", "100"})
+
+	losing := &peerConn{sid: "1AB"}
+	if h.admitRemote(losing, peerMessage{command: "UID", params: []string{"alice", "1", "200"}}) {
+		t.Error("admitRemote admitted a higher-TS remote UID against an existing local claim")
+	}
+
+	winning := &peerConn{sid: "1AB"}
+	if !h.admitRemote(winning, peerMessage{command: "UID", params: []string{"bob", "1", "50"}}) {
+		t.Error("admitRemote rejected a remote UID for a name with no existing claim")
+	}
+}
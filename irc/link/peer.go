@@ -0,0 +1,219 @@
+package link
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultPingFrequency is used in place of an unconfigured (zero)
+// Config.PingFrequency, so an idle-but-healthy link always gets an active
+// keepalive instead of silently relying on readLoop's deadline to notice a
+// half-open socket.
+const defaultPingFrequency = time.Minute
+
+// peerHandler mirrors the irc package's client handler chain: each line
+// returns the handler that should process the next one, so handshake,
+// burst and steady-state each get their own type instead of one big switch.
+type peerHandler interface {
+	handle(p *peerConn, msg peerMessage) peerHandler
+	closed(p *peerConn)
+}
+
+// peerConn is one S2S link to another channels node, inbound or outbound.
+type peerConn struct {
+	hub     *Hub
+	conn    net.Conn
+	handler peerHandler
+
+	// name and sid are populated once the SERVER line is seen.
+	name string
+	sid  string
+
+	outbox   chan peerMessage
+	killRead chan struct{}
+	killSend chan struct{}
+	killPing chan struct{}
+}
+
+func newPeerConn(hub *Hub, conn net.Conn, initial peerHandler) *peerConn {
+	return &peerConn{
+		hub:      hub,
+		conn:     conn,
+		handler:  initial,
+		outbox:   make(chan peerMessage, 64),
+		killRead: make(chan struct{}, 1),
+		killSend: make(chan struct{}, 1),
+		killPing: make(chan struct{}, 1),
+	}
+}
+
+func (p *peerConn) send(msg peerMessage) {
+	select {
+	case p.outbox <- msg:
+	default:
+		logrus.Warnf("link: outbox full for peer %s, dropping %s", p.name, msg.command)
+	}
+}
+
+func (p *peerConn) kill() {
+	go func() {
+		p.killRead <- struct{}{}
+		p.killSend <- struct{}{}
+		p.killPing <- struct{}{}
+	}()
+}
+
+// loop runs the peer connection until it is killed or the socket closes.
+func (p *peerConn) loop() {
+	go p.sendLoop()
+	go p.pingLoop()
+	p.readLoop()
+}
+
+// pingLoop actively pings an idle peer link every Config.PingFrequency,
+// distinct from the client-facing connectionImpl.pingLoop: peerEstablishedHandler
+// already replies to PINGs it receives, but without a periodic PING of our
+// own a silent, half-open link to a peer would never be noticed.
+func (p *peerConn) pingLoop() {
+	ticker := time.NewTicker(p.pingFrequency())
+	defer ticker.Stop()
+
+	alive := true
+	for alive {
+		select {
+		case <-p.killPing:
+			alive = false
+		case <-ticker.C:
+			p.send(peerMessage{command: "PING", params: []string{p.hub.config.SID}})
+		}
+	}
+}
+
+// pingFrequency is how often pingLoop sends a keepalive PING, falling back
+// to defaultPingFrequency when Config.PingFrequency is unset (<=0) so an
+// unconfigured link still gets an active keepalive instead of none.
+func (p *peerConn) pingFrequency() time.Duration {
+	if p.hub.config.PingFrequency > 0 {
+		return p.hub.config.PingFrequency
+	}
+	return defaultPingFrequency
+}
+
+func (p *peerConn) readLoop() {
+	r := bufio.NewReader(p.conn)
+	parser := newPeerMessageParser(r)
+
+	// readDeadline gives a few missed keepalives' worth of slack before
+	// declaring the link dead, so it never fires tighter than pingLoop's
+	// own cadence can keep up with.
+	readDeadline := 3 * p.pingFrequency()
+
+	alive := true
+	for alive {
+		select {
+		case <-p.killRead:
+			alive = false
+		default:
+			p.conn.SetReadDeadline(time.Now().Add(readDeadline))
+			msg, ok := parser()
+			if !ok {
+				alive = false
+				break
+			}
+			if msg.command == "" {
+				continue
+			}
+			logrus.Debugf("link recv from %s: %+v", p.name, msg)
+			p.handler = p.handler.handle(p, msg)
+		}
+	}
+
+	p.handler.closed(p)
+	p.conn.Close()
+}
+
+func (p *peerConn) sendLoop() {
+	alive := true
+	for alive {
+		select {
+		case <-p.killSend:
+			alive = false
+		case msg := <-p.outbox:
+			if _, err := io.WriteString(p.conn, msg.toLine()); err != nil {
+				logrus.Warnf("link: write to peer %s failed: %v", p.name, err)
+			}
+		}
+	}
+	p.conn.Close()
+}
+
+// peerHandshakeHandler waits for PASS then SERVER, per a simplified TS6
+// opening exchange, and authenticates the peer against the shared secret.
+type peerHandshakeHandler struct {
+	pass string
+	sid  string
+}
+
+func (h *peerHandshakeHandler) handle(p *peerConn, msg peerMessage) peerHandler {
+	switch msg.command {
+	case "PASS":
+		// PASS <link-password> TS 6 <sid>, per the dialLoop/hub send side.
+		if len(msg.params) > 0 {
+			h.pass = msg.params[0]
+		}
+		if len(msg.params) > 3 {
+			h.sid = msg.params[3]
+		}
+		return h
+	case "SERVER":
+		if len(msg.params) < 1 || h.pass != p.hub.config.LinkPassword {
+			logrus.Warnf("link: rejecting peer %s: bad PASS", msg.params)
+			p.kill()
+			return &peerClosedHandler{}
+		}
+		p.name = msg.params[0]
+		p.sid = h.sid
+		logrus.Infof("link: peer %s (sid %s) authenticated", p.name, p.sid)
+		p.hub.registerPeer(p)
+		p.hub.BurstTo(p)
+		return &peerEstablishedHandler{}
+	default:
+		return h
+	}
+}
+
+func (h *peerHandshakeHandler) closed(p *peerConn) {}
+
+// peerEstablishedHandler applies UID/SJOIN bursts and steady-state events
+// (PRIVMSG/JOIN/PART/QUIT/NICK/MODE/TOPIC) from an authenticated peer.
+type peerEstablishedHandler struct{}
+
+func (h *peerEstablishedHandler) handle(p *peerConn, msg peerMessage) peerHandler {
+	switch msg.command {
+	case "PING":
+		p.send(peerMessage{command: "PONG", params: msg.params})
+		return h
+	case "PONG":
+		return h
+	case "UID", "SJOIN", "PRIVMSG", "NOTICE", "JOIN", "PART", "QUIT", "NICK", "MODE", "TOPIC":
+		p.hub.applyRemote(p, msg)
+		return h
+	default:
+		logrus.Debugf("link: unhandled command from peer %s: %s", p.name, msg.command)
+		return h
+	}
+}
+
+func (h *peerEstablishedHandler) closed(p *peerConn) {
+	p.hub.unregisterPeer(p)
+}
+
+// peerClosedHandler discards every line on a link that's being torn down.
+type peerClosedHandler struct{}
+
+func (peerClosedHandler) handle(p *peerConn, msg peerMessage) peerHandler { return peerClosedHandler{} }
+func (peerClosedHandler) closed(p *peerConn)                              {}
@@ -0,0 +1,66 @@
+// Package link implements a simplified TS6-style server-to-server protocol
+// so multiple channels processes can mesh into one network, mirroring the
+// client-facing handler chain in package irc but for peer connections.
+package link
+
+import (
+	"bufio"
+	"strings"
+)
+
+// peerMessage is one line of the S2S protocol: a command and its
+// space-separated params, with the last param allowed to contain spaces
+// when prefixed with ':', exactly like the client IRC line grammar.
+type peerMessage struct {
+	command string
+	params  []string
+}
+
+// parsePeerMessage parses a single S2S protocol line.
+func parsePeerMessage(line string) (peerMessage, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return peerMessage{}, false
+	}
+
+	fields := strings.SplitN(line, " :", 2)
+	head := strings.Fields(fields[0])
+	if len(head) == 0 {
+		return peerMessage{}, false
+	}
+
+	msg := peerMessage{command: strings.ToUpper(head[0]), params: head[1:]}
+	if len(fields) == 2 {
+		msg.params = append(msg.params, fields[1])
+	}
+	return msg, true
+}
+
+// toLine renders a peerMessage back to wire format.
+func (m peerMessage) toLine() string {
+	var b strings.Builder
+	b.WriteString(m.command)
+	for i, p := range m.params {
+		b.WriteByte(' ')
+		last := i == len(m.params)-1
+		if last && (strings.Contains(p, " ") || strings.HasPrefix(p, ":") || p == "") {
+			b.WriteByte(':')
+		}
+		b.WriteString(p)
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// newPeerMessageParser returns a function reading successive peerMessages
+// off r, modeled on the client-protocol parser in the irc package.
+func newPeerMessageParser(r *bufio.Reader) func() (peerMessage, bool) {
+	return func() (peerMessage, bool) {
+		line, err := r.ReadString('\n')
+		if err != nil && line == "" {
+			return peerMessage{}, false
+		}
+		msg, _ := parsePeerMessage(line)
+		return msg, true
+	}
+}
@@ -0,0 +1,74 @@
+package link
+
+import "testing"
+
+func TestParsePeerMessage(t *testing.T) {
+	cases := []struct {
+		name string
+		line string
+		want peerMessage
+		ok   bool
+	}{
+		{"empty line", "", peerMessage{}, false},
+		{"command only", "PING\r\n", peerMessage{command: "PING"}, true},
+		{"params no trailing", "UID alice 1 100\r\n", peerMessage{command: "UID", params: []string{"alice", "1", "100"}}, true},
+		{
+			"trailing with spaces",
+			"PRIVMSG #chan :hello there\r\n",
+			peerMessage{command: "PRIVMSG", params: []string{"#chan", "hello there"}},
+			true,
+		},
+		{"lowercase command upcased", "ping\r\n", peerMessage{command: "PING"}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := parsePeerMessage(c.line)
+			if ok != c.ok {
+				t.Fatalf("parsePeerMessage(%q) ok = %v, want %v", c.line, ok, c.ok)
+			}
+			if !ok {
+				return
+			}
+			if got.command != c.want.command || !paramsEqual(got.params, c.want.params) {
+				t.Errorf("parsePeerMessage(%q) = %+v, want %+v", c.line, got, c.want)
+			}
+		})
+	}
+}
+
+func TestPeerMessageToLine(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  peerMessage
+		want string
+	}{
+		{"no params", peerMessage{command: "PING"}, "PING\r\n"},
+		{"params no trailing needed", peerMessage{command: "UID", params: []string{"alice", "1"}}, "UID alice 1\r\n"},
+		{
+			"last param needs colon",
+			peerMessage{command: "PRIVMSG", params: []string{"#chan", "hello there"}},
+			"PRIVMSG #chan :hello there\r\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.msg.toLine(); got != c.want {
+				t.Errorf("toLine() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func paramsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
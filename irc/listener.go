@@ -0,0 +1,31 @@
+package irc
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// Listen opens a TCP listener on addr, wrapping it with TLS when the config
+// requests an implicit-TLS port. Plaintext listeners still support
+// upgrading individual connections later via STARTTLS when config.TLS is
+// set.
+func Listen(config *Config, addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.TLSListen && config.TLS != nil {
+		return tls.NewListener(ln, tlsServerConfig(config.TLS)), nil
+	}
+	return ln, nil
+}
+
+// tlsServerConfig returns a copy of cfg with client certificates requested
+// but not required, so SASL EXTERNAL can use one when a client presents it
+// without locking out clients that authenticate another way.
+func tlsServerConfig(cfg *tls.Config) *tls.Config {
+	out := cfg.Clone()
+	out.ClientAuth = tls.RequestClientCert
+	return out
+}
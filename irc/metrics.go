@@ -0,0 +1,48 @@
+package irc
+
+import "sync"
+
+// ConnMetrics counts bytes and messages per command seen on one connection.
+// It is exported so code embedding this package can inspect traffic without
+// adding its own Handlers registration just for bookkeeping.
+type ConnMetrics struct {
+	mu       sync.Mutex
+	messages map[string]int
+	bytes    int64
+}
+
+func newConnMetrics() *ConnMetrics {
+	return &ConnMetrics{messages: make(map[string]int)}
+}
+
+// record is called for every message a connection reads off its socket,
+// before any registered Handlers callback runs. Injected messages (such as
+// a faked QUIT) are not recorded.
+func (m *ConnMetrics) record(msg message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages[msg.command]++
+	if line, ok := msg.toString(); ok {
+		m.bytes += int64(len(line))
+	}
+}
+
+// Messages returns a snapshot of the per-command message counts seen so far.
+func (m *ConnMetrics) Messages() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string]int, len(m.messages))
+	for cmd, n := range m.messages {
+		out[cmd] = n
+	}
+	return out
+}
+
+// Bytes returns the total serialized size of every message seen so far.
+func (m *ConnMetrics) Bytes() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bytes
+}
@@ -0,0 +1,6 @@
+package irc
+
+// errorTooManyTargets is sent in place of a dropped message when a
+// connection's inbox is flooded past its high-water mark, styled after
+// ERR_TOOMANYTARGETS rather than silently discarding the outbound message.
+var errorTooManyTargets = numeric{code: 407, text: "Too many messages queued, message dropped"}
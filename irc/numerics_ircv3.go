@@ -0,0 +1,13 @@
+package irc
+
+// SASL numerics, per IRCv3 sasl-3.2.
+var (
+	replyLoggedIn    = numeric{code: 900, text: "You are now logged in"}
+	replySaslSuccess = numeric{code: 903, text: "SASL authentication successful"}
+	errorSaslFail    = numeric{code: 904, text: "SASL authentication failed"}
+	errorSaslAborted = numeric{code: 906, text: "SASL authentication aborted"}
+)
+
+// errorUnknownCommand is returned for unrecognised CAP subcommands and other
+// commands the server does not implement.
+var errorUnknownCommand = numeric{code: 421, text: "Unknown command"}
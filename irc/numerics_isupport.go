@@ -0,0 +1,5 @@
+package irc
+
+// errorErroneousNickname is returned when a requested nick exceeds the
+// advertised NICKLEN.
+var errorErroneousNickname = numeric{code: 432, text: "Erroneous nickname"}
@@ -0,0 +1,7 @@
+package irc
+
+// STARTTLS numerics, per IRCv3 tls-3.1.
+var (
+	replyStarttls = numeric{code: 670, text: "STARTTLS successful, proceed with TLS handshake"}
+	errorStarttls = numeric{code: 691, text: "STARTTLS failed"}
+)
@@ -0,0 +1,162 @@
+package irc
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"channels/state"
+)
+
+// saslChunkSize is the maximum number of base64 bytes the client may send
+// per AUTHENTICATE line; a line of exactly this length means more chunks
+// follow, and the first shorter line (a lone "+" when nothing is left) ends
+// the payload.
+const saslChunkSize = 400
+
+// saslCap gates registration on SASL completing once requested, per the
+// IRCv3 sasl-3.2 extension.
+type saslCap struct{}
+
+func (saslCap) onEnable(cs *capNegState) {
+	cs.saslRequested = true
+}
+
+func (saslCap) onDisable(cs *capNegState) {
+	cs.saslRequested = false
+	cs.sasl = saslSession{}
+}
+
+// blocksRegistration reports whether registration must wait: the client
+// REQ'd sasl but hasn't completed it yet, either because AUTHENTICATE is
+// still in progress or hasn't been tried at all. It stops being true once
+// AUTHENTICATE succeeds (saslCaller is set) or the client drops the cap via
+// CAP REQ :-sasl.
+func (cs *capNegState) blocksRegistration() bool {
+	return cs.saslRequested && cs.saslCaller == nil
+}
+
+// saslSession tracks an in-progress AUTHENTICATE exchange across the
+// possibly-chunked lines a client sends before the mechanism can be run.
+type saslSession struct {
+	mechanism string
+	buf       strings.Builder
+	started   bool
+}
+
+// handleAuthenticateCommand processes one AUTHENTICATE line against the
+// shared negotiation state. Mechanism selection happens on the first line;
+// every following line is a base64 chunk (or the literal "+" for an empty
+// chunk) until a chunk shorter than saslChunkSize bytes terminates the
+// payload.
+func handleAuthenticateCommand(cs *capNegState, stateCh chan state.State, conn connection, msg message) {
+	s := <-stateCh
+	defer func() { stateCh <- s }()
+
+	if len(msg.params) < 1 {
+		sendNumeric(s, conn.send, errorNeedMoreParams)
+		return
+	}
+	arg := msg.params[0]
+
+	if arg == "*" {
+		sendNumeric(s, conn.send, errorSaslAborted)
+		cs.sasl = saslSession{}
+		return
+	}
+
+	if !cs.sasl.started {
+		switch strings.ToUpper(arg) {
+		case "PLAIN", "EXTERNAL":
+			cs.sasl = saslSession{mechanism: strings.ToUpper(arg), started: true}
+			conn.send(cmdAuthenticate.withTrailing("+"))
+		default:
+			sendNumeric(s, conn.send, errorSaslFail)
+			cs.sasl = saslSession{}
+		}
+		return
+	}
+
+	if arg != "+" {
+		cs.sasl.buf.WriteString(arg)
+	}
+	if len(arg) == saslChunkSize {
+		// More chunks to come.
+		return
+	}
+
+	finishAuthenticate(cs, s, conn)
+}
+
+// finishAuthenticate decodes the accumulated payload and runs it against the
+// selected mechanism, replying with the standard SASL numerics.
+func finishAuthenticate(cs *capNegState, s state.State, conn connection) {
+	defer func() { cs.sasl = saslSession{} }()
+
+	raw, err := base64.StdEncoding.DecodeString(cs.sasl.buf.String())
+	if err != nil {
+		logrus.Debugf("sasl: bad base64: %v", err)
+		sendNumeric(s, conn.send, errorSaslFail)
+		return
+	}
+
+	var authzid, authnid, password string
+	switch cs.sasl.mechanism {
+	case "PLAIN":
+		parts := strings.SplitN(string(raw), "\x00", 3)
+		if len(parts) != 3 {
+			sendNumeric(s, conn.send, errorSaslFail)
+			return
+		}
+		authzid, authnid, password = parts[0], parts[1], parts[2]
+	case "EXTERNAL":
+		authzid = string(raw)
+		finishExternalAuthenticate(cs, s, conn, authzid)
+		return
+	}
+
+	caller, err := s.Auth(authnid, password)
+	if err != nil {
+		logrus.Debugf("sasl auth failed for %s: %v", authnid, err)
+		sendNumeric(s, conn.send, errorSaslFail)
+		return
+	}
+
+	cs.saslCaller = caller
+	cs.saslAccount = caller.Name
+	if authzid == "" {
+		authzid = caller.Name
+	}
+	sendNumeric(s, conn.send, replyLoggedIn)
+	sendNumeric(s, conn.send, replySaslSuccess)
+}
+
+// finishExternalAuthenticate authenticates the sasl EXTERNAL mechanism
+// solely from the client certificate presented during the TLS handshake:
+// the authzid payload, if any, is just a hint, never a credential.
+func finishExternalAuthenticate(cs *capNegState, s state.State, conn connection, authzid string) {
+	tlsState := conn.tlsConnectionState()
+	if tlsState == nil || len(tlsState.PeerCertificates) == 0 {
+		sendNumeric(s, conn.send, errorSaslFail)
+		return
+	}
+
+	identity := externalIdentity(tlsState.PeerCertificates[0])
+	if identity == "" {
+		sendNumeric(s, conn.send, errorSaslFail)
+		return
+	}
+
+	caller, err := s.AuthCert(identity)
+	if err != nil {
+		logrus.Debugf("sasl external auth failed for %s: %v", identity, err)
+		sendNumeric(s, conn.send, errorSaslFail)
+		return
+	}
+
+	cs.saslCaller = caller
+	cs.saslAccount = caller.Name
+	sendNumeric(s, conn.send, replyLoggedIn)
+	sendNumeric(s, conn.send, replySaslSuccess)
+}
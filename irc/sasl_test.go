@@ -0,0 +1,51 @@
+package irc
+
+import (
+	"testing"
+
+	"channels/state"
+)
+
+// TestHandleAuthenticateCommandAbort guards the "AUTHENTICATE *" abort path
+// (IRCv3 sasl-3.2): it must reset the in-progress exchange and reply with
+// ERR_SASLABORTED (906), not the unrelated, similarly-numbered
+// ERR_SASLTOOLONG (905).
+func TestHandleAuthenticateCommandAbort(t *testing.T) {
+	cs := &capNegState{saslRequested: true}
+	cs.sasl = saslSession{mechanism: "PLAIN", started: true}
+	stateCh := make(chan state.State, 1)
+	stateCh <- nil
+	conn := &fakeConn{}
+
+	handleAuthenticateCommand(cs, stateCh, conn, message{command: cmdAuthenticate.command, params: []string{"*"}})
+
+	if cs.sasl.started {
+		t.Error("sasl session still marked started after abort")
+	}
+	if len(conn.sent) != 1 || conn.sent[0].command != "906" {
+		t.Fatalf("sent = %+v, want a single 906 (ERR_SASLABORTED) numeric", conn.sent)
+	}
+}
+
+func TestCapNegStateBlocksRegistration(t *testing.T) {
+	cases := []struct {
+		name          string
+		saslRequested bool
+		saslCaller    *state.Caller
+		want          bool
+	}{
+		{"sasl never requested", false, nil, false},
+		{"sasl requested, not yet authenticated", true, nil, true},
+		{"sasl requested and authenticated", true, &state.Caller{}, false},
+		{"sasl not requested but caller set anyway", false, &state.Caller{}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cs := &capNegState{saslRequested: c.saslRequested, saslCaller: c.saslCaller}
+			if got := cs.blocksRegistration(); got != c.want {
+				t.Errorf("blocksRegistration() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
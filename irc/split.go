@@ -0,0 +1,80 @@
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultMaxLineBytes is the classic IRC line length limit, used when
+// Config.MaxLineBytes is unset.
+const defaultMaxLineBytes = 512
+
+// splitMessage breaks a PRIVMSG/NOTICE whose serialized line would exceed
+// maxBytes into multiple messages carrying the same command and target
+// params, each holding a slice of the original trailing text. tagBytes
+// reserves room for IRCv3 message tags the peer has negotiated; pass 0 when
+// the peer hasn't negotiated message-tags. Only PRIVMSG/NOTICE are ever
+// split - anything else is returned unchanged.
+func splitMessage(msg message, maxBytes, tagBytes int) []message {
+	if msg.command != cmdPrivmsg.command && msg.command != cmdNotice.command {
+		return []message{msg}
+	}
+
+	if line, ok := msg.toString(); ok && len(line)+tagBytes <= maxBytes {
+		return []message{msg}
+	}
+
+	budget := lineBudget(msg, maxBytes) - tagBytes
+	if budget <= 0 || msg.trailing == "" {
+		return []message{msg}
+	}
+
+	var out []message
+	remaining := msg.trailing
+	for len(remaining) > 0 {
+		chunk, rest := splitChunk(remaining, budget)
+		if chunk == "" {
+			// Budget too small to make progress; stop rather than looping.
+			break
+		}
+		part := msg
+		part.trailing = chunk
+		out = append(out, part)
+		remaining = rest
+	}
+	return out
+}
+
+// lineBudget computes how many bytes of trailing text fit in one line,
+// given the command and target params but an empty trailing.
+func lineBudget(msg message, maxBytes int) int {
+	head := message{command: msg.command, params: msg.params}
+	line, _ := head.toString()
+	// head's line already accounts for "\r\n"; the trailing text still
+	// needs its leading ':' once non-empty.
+	return maxBytes - len(line) - len(":")
+}
+
+// splitChunk takes the largest prefix of s that fits in budget bytes
+// without splitting a UTF-8 code point, preferring to break on the last
+// whitespace within that prefix when one exists, and returns the chunk and
+// the unconsumed remainder.
+func splitChunk(s string, budget int) (chunk, rest string) {
+	if budget <= 0 {
+		return "", s
+	}
+	if len(s) <= budget {
+		return s, ""
+	}
+
+	end := budget
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+
+	if sp := strings.LastIndexByte(s[:end], ' '); sp > 0 {
+		end = sp
+	}
+
+	return s[:end], strings.TrimPrefix(s[end:], " ")
+}
@@ -0,0 +1,82 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitChunk(t *testing.T) {
+	cases := []struct {
+		name      string
+		s         string
+		budget    int
+		wantChunk string
+		wantRest  string
+	}{
+		{"fits whole", "hello", 10, "hello", ""},
+		{"zero budget", "hello", 0, "", "hello"},
+		{"splits on whitespace", "hello world", 8, "hello", "world"},
+		{"no whitespace splits at budget", "helloworld", 5, "hello", "world"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chunk, rest := splitChunk(c.s, c.budget)
+			if chunk != c.wantChunk || rest != c.wantRest {
+				t.Errorf("splitChunk(%q, %d) = (%q, %q), want (%q, %q)",
+					c.s, c.budget, chunk, rest, c.wantChunk, c.wantRest)
+			}
+		})
+	}
+}
+
+func TestSplitMessageUnderBudget(t *testing.T) {
+	msg := message{command: cmdPrivmsg.command, params: []string{"#chan"}, trailing: "hi"}
+	parts := splitMessage(msg, defaultMaxLineBytes, 0)
+	if len(parts) != 1 || parts[0].trailing != "hi" {
+		t.Fatalf("splitMessage under budget = %+v, want a single unchanged message", parts)
+	}
+}
+
+func TestSplitMessageOversize(t *testing.T) {
+	msg := message{command: cmdPrivmsg.command, params: []string{"#chan"}, trailing: strings.Repeat("a", 100)}
+
+	parts := splitMessage(msg, 40, 0)
+	if len(parts) < 2 {
+		t.Fatalf("splitMessage with oversize trailing produced %d parts, want more than 1", len(parts))
+	}
+
+	var rejoined strings.Builder
+	for _, p := range parts {
+		if p.command != cmdPrivmsg.command || len(p.params) != 1 || p.params[0] != "#chan" {
+			t.Fatalf("split part lost command/target: %+v", p)
+		}
+		if line, ok := p.toString(); !ok || len(line) > 40 {
+			t.Errorf("split part exceeds maxBytes: %q (len %d)", line, len(line))
+		}
+		rejoined.WriteString(p.trailing)
+	}
+	if rejoined.String() != msg.trailing {
+		t.Errorf("rejoined trailing = %q, want %q", rejoined.String(), msg.trailing)
+	}
+}
+
+func TestSplitMessageReservesTagBytes(t *testing.T) {
+	msg := message{command: cmdPrivmsg.command, params: []string{"#chan"}, trailing: strings.Repeat("a", 100)}
+
+	withoutTags := splitMessage(msg, 80, 0)
+	withTags := splitMessage(msg, 80, 40)
+
+	if len(withTags) <= len(withoutTags) {
+		t.Errorf("reserving tagBytes produced %d parts, want more than the %d parts with no reservation",
+			len(withTags), len(withoutTags))
+	}
+}
+
+func TestSplitMessageIgnoresOtherCommands(t *testing.T) {
+	msg := message{command: "JOIN", params: []string{"#chan"}, trailing: strings.Repeat("a", 1000)}
+	parts := splitMessage(msg, 40, 0)
+	if len(parts) != 1 {
+		t.Errorf("splitMessage split a non-PRIVMSG/NOTICE command into %d parts, want 1 unchanged", len(parts))
+	}
+}
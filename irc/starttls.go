@@ -0,0 +1,49 @@
+package irc
+
+import (
+	"crypto/x509"
+
+	"github.com/sirupsen/logrus"
+
+	"channels/state"
+)
+
+func init() {
+	serverCaps["tls"] = capability{name: "tls"}
+}
+
+// cmdStarttls is the STARTTLS command, per IRCv3 tls-3.1.
+var cmdStarttls = message{command: "STARTTLS"}
+
+// handleStarttlsCommand upgrades conn to TLS in place using config.TLS, per
+// the STARTTLS/670/691 exchange. It is only meaningful pre-registration,
+// since clients are expected to STARTTLS before NICK/USER or CAP END.
+func handleStarttlsCommand(config *Config, stateCh chan state.State, conn connection, msg message) {
+	s := <-stateCh
+	defer func() { stateCh <- s }()
+
+	if config.TLS == nil || conn.tlsConnectionState() != nil {
+		sendNumeric(s, conn.send, errorStarttls)
+		return
+	}
+
+	sendNumeric(s, conn.send, replyStarttls)
+
+	if err := conn.startTLS(tlsServerConfig(config.TLS)); err != nil {
+		logrus.Warnf("STARTTLS handshake failed: %v", err)
+		conn.kill()
+	}
+}
+
+// externalIdentity derives the identity sasl EXTERNAL authenticates as from
+// a client certificate: its Subject CN, falling back to the first SAN email
+// address when the CN is empty.
+func externalIdentity(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
@@ -0,0 +1,41 @@
+package irc
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"channels/state"
+)
+
+func TestTlsServerConfigRequestsClientCertWithoutRequiringIt(t *testing.T) {
+	out := tlsServerConfig(&tls.Config{})
+
+	if out.ClientAuth != tls.RequestClientCert {
+		t.Errorf("ClientAuth = %v, want RequestClientCert (optional, so non-cert auth like SASL PLAIN still works)", out.ClientAuth)
+	}
+}
+
+func TestHandleStarttlsCommandFailsWithoutTLSConfig(t *testing.T) {
+	conn := &fakeConn{}
+	stateCh := make(chan state.State, 1)
+	stateCh <- nil
+
+	handleStarttlsCommand(&Config{}, stateCh, conn, message{command: cmdStarttls.command})
+
+	if len(conn.sent) != 1 || conn.sent[0].command != "691" {
+		t.Fatalf("sent = %+v, want a single 691 (ERR_STARTTLS)", conn.sent)
+	}
+}
+
+func TestHandleStarttlsCommandUpgradesWhenConfigured(t *testing.T) {
+	conn := &fakeConn{}
+	stateCh := make(chan state.State, 1)
+	stateCh <- nil
+	config := &Config{TLS: &tls.Config{}}
+
+	handleStarttlsCommand(config, stateCh, conn, message{command: cmdStarttls.command})
+
+	if len(conn.sent) != 1 || conn.sent[0].command != "670" {
+		t.Fatalf("sent = %+v, want a single 670 (RPL_STARTTLS) before the handshake", conn.sent)
+	}
+}